@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// ErrorEvent describes a failure that occurred while rendering a
+// page, so downstream integrations can wire renderError into their
+// own structured logging or metrics pipeline instead of losing
+// panics and template errors to stderr.
+type ErrorEvent struct {
+	Status    int
+	Template  string
+	RequestID string
+	Err       error
+}
+
+// errorPageContext is the template context for errorpage.html.
+type errorPageContext struct {
+	Status     int
+	Message    string
+	Production bool
+	RequestID  string
+	// Stack holds a stack trace for the failure. Only populated
+	// outside of production, where it's safe to expose to whoever
+	// is looking at the response.
+	Stack string
+}
+
+// SetErrorHook registers a callback invoked, in addition to the
+// response written to the client, for every error renderError
+// renders.
+func (s *server) SetErrorHook(hook func(ErrorEvent)) {
+	s.t.errorHook = hook
+}
+
+// renderError renders status through the errorPage template: a
+// sanitized status message in production, or the underlying error
+// and a stack trace in dev to speed up debugging template bugs.
+func (s *server) renderError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	ctx := errorPageContext{
+		Status:     status,
+		Message:    http.StatusText(status),
+		Production: s.config.Production,
+		RequestID:  r.Header.Get("X-Request-Id"),
+	}
+	if !s.config.Production && err != nil {
+		ctx.Message = err.Error()
+		ctx.Stack = string(debug.Stack())
+	}
+
+	if s.t.errorHook != nil {
+		s.t.errorHook(ErrorEvent{
+			Status:    status,
+			Template:  "errorpage.html",
+			RequestID: ctx.RequestID,
+			Err:       err,
+		})
+	}
+
+	w.WriteHeader(status)
+	s.renderErrorBody(w, r, status, ctx)
+}
+
+// renderErrorBody renders ctx through the errorPage template, with
+// its own recover: resolveTemplates and Execute can both still panic
+// (e.g. a theme whose templates fail to parse via template.Must), and
+// renderError is itself the path recoverRender falls back to on a
+// panic, so a second panic here has nowhere left to go but past
+// renderPage entirely, leaving the client with no response at all.
+// Falling back to the same plain-text path used when no errorPage
+// template exists keeps that from happening.
+func (s *server) renderErrorBody(w http.ResponseWriter, r *http.Request, status int, ctx errorPageContext) {
+	defer func() {
+		if recover() != nil {
+			fmt.Fprintf(w, "%d %s\n", status, ctx.Message)
+		}
+	}()
+
+	tmpl := s.resolveTemplates(w, r)
+	if tmpl.errorPage == nil {
+		fmt.Fprintf(w, "%d %s\n", status, ctx.Message)
+		return
+	}
+	if execErr := tmpl.errorPage.Execute(w, ctx); execErr != nil {
+		fmt.Fprintf(w, "%d %s\n", status, ctx.Message)
+	}
+}
+
+// recoverRender converts a panic during page rendering (e.g. from a
+// broken template function) into a 500 served through renderError,
+// instead of letting it crash the request unanswered. Call it with
+// defer at the top of any handler that executes templates.
+func (s *server) recoverRender(w http.ResponseWriter, r *http.Request) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+	err, ok := rec.(error)
+	if !ok {
+		err = fmt.Errorf("%v", rec)
+	}
+	s.renderError(w, r, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nelhage/livegrep/config"
+)
+
+// SuggestionSource supplies autocomplete candidates for a query
+// prefix, in priority order. Implementations back the OpenSearch
+// suggestions endpoint: the built-in recentQueries tracker,
+// backendTokenSource for backend ids (repo and path names are not
+// suggested), and a caller-supplied symbol source (see SetSymbolSource).
+type SuggestionSource interface {
+	Suggest(prefix string, limit int) []string
+}
+
+// maxRecentQueries bounds the list of past queries kept for
+// suggestions; older queries are evicted first.
+const maxRecentQueries = 200
+
+// recentQueries tracks the most recent distinct search queries seen
+// by this server, most-recent first, for use as a SuggestionSource.
+type recentQueries struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func newRecentQueries() *recentQueries {
+	return &recentQueries{}
+}
+
+// Record adds q to the front of the recent-queries list, moving it
+// there if already present, and evicts the oldest entry once the
+// list exceeds maxRecentQueries.
+func (r *recentQueries) Record(q string) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.queries {
+		if existing == q {
+			r.queries = append(r.queries[:i], r.queries[i+1:]...)
+			break
+		}
+	}
+	r.queries = append([]string{q}, r.queries...)
+	if len(r.queries) > maxRecentQueries {
+		r.queries = r.queries[:maxRecentQueries]
+	}
+}
+
+func (r *recentQueries) Suggest(prefix string, limit int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for _, q := range r.queries {
+		if len(out) >= limit {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(q), strings.ToLower(prefix)) {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+// backendTokenSource offers the configured backends' ids as
+// suggestions, so typing a backend-name prefix completes to a
+// runnable query.
+type backendTokenSource struct {
+	tokens []string
+}
+
+func newBackendTokenSource(backends []config.Backend) *backendTokenSource {
+	seen := make(map[string]bool)
+	var tokens []string
+	for _, b := range backends {
+		if b.Id != "" && !seen[b.Id] {
+			seen[b.Id] = true
+			tokens = append(tokens, b.Id)
+		}
+	}
+	sort.Strings(tokens)
+	return &backendTokenSource{tokens: tokens}
+}
+
+func (s *backendTokenSource) Suggest(prefix string, limit int) []string {
+	var out []string
+	for _, t := range s.tokens {
+		if len(out) >= limit {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(t), strings.ToLower(prefix)) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// maxSuggestions caps the number of candidates returned by the
+// suggest endpoint, across all sources combined.
+const maxSuggestions = 10
+
+// suggestionSources returns the ordered list of SuggestionSources
+// this server queries for autocomplete, recent queries first, then
+// backend ids, then the symbol source (if one has been
+// registered), lazily initializing the built-in ones on first use.
+func (s *server) suggestionSources() []SuggestionSource {
+	if s.t.recentQueries == nil {
+		s.t.recentQueries = newRecentQueries()
+	}
+	if s.t.backendTokens == nil {
+		s.t.backendTokens = newBackendTokenSource(s.config.Backends)
+	}
+	sources := []SuggestionSource{s.t.recentQueries, s.t.backendTokens}
+	if s.t.symbolSource != nil {
+		sources = append(sources, s.t.symbolSource)
+	}
+	return sources
+}
+
+// SetSymbolSource registers src as the source of top-symbol-name
+// suggestions. livegrep's symbol index lives in the codesearch
+// backend, which this package talks to only through config.Backend,
+// so the backend integration is expected to build a SuggestionSource
+// over its own symbol index and wire it in here, the same way
+// SetErrorHook lets a downstream integration supply its own logging.
+func (s *server) SetSymbolSource(src SuggestionSource) {
+	s.t.symbolSource = src
+}
+
+// recordQuery records q as having been searched, so it becomes a
+// candidate suggestion for future prefixes. Call this from the
+// search handler once a query is known to be well-formed.
+func (s *server) recordQuery(q string) {
+	if s.t.recentQueries == nil {
+		s.t.recentQueries = newRecentQueries()
+	}
+	s.t.recentQueries.Record(q)
+}
+
+// ServeOpenSearchSuggest implements the OpenSearch Suggestions
+// extension: given ?q=<prefix>, it responds with the 4-element JSON
+// array browsers expect for inline address-bar completion:
+// [query, [suggestions], [descriptions], [urls]].
+func (s *server) ServeOpenSearchSuggest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+
+	var suggestions []string
+	seen := make(map[string]bool)
+	for _, src := range s.suggestionSources() {
+		for _, sug := range src.Suggest(q, maxSuggestions) {
+			if seen[sug] || len(suggestions) >= maxSuggestions {
+				continue
+			}
+			seen[sug] = true
+			suggestions = append(suggestions, sug)
+		}
+	}
+
+	descriptions := make([]string, len(suggestions))
+	urls := make([]string, len(suggestions))
+	for i, sug := range suggestions {
+		urls[i] = "/search?q=" + url.QueryEscape(sug)
+	}
+
+	w.Header().Set("Content-Type", "application/x-suggestions+json; charset=utf-8")
+	json.NewEncoder(w).Encode([]interface{}{q, suggestions, descriptions, urls})
+}
+
+// ServeOpenSearchXML serves the OpenSearch description document,
+// advertising the suggestions endpoint as an
+// application/x-suggestions+json Url so browsers offer inline
+// address-bar completion from ServeOpenSearchSuggest.
+func (s *server) ServeOpenSearchXML(w http.ResponseWriter, r *http.Request) {
+	defer s.recoverRender(w, r)
+
+	tmpl := s.resolveTemplates(w, r)
+
+	base := baseURL(r)
+	ctx := opensearchContext{
+		BackendName: s.defaultBackendName(),
+		BaseURL:     base,
+		SuggestURL:  base + "/opensearch/suggest",
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+	if err := tmpl.opensearchXML.Execute(w, ctx); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// defaultBackendName returns the name of the first configured
+// backend, for the OpenSearch description's ShortName, or "" if none
+// are configured.
+func (s *server) defaultBackendName() string {
+	if len(s.config.Backends) == 0 {
+		return ""
+	}
+	return s.config.Backends[0].Id
+}
+
+// baseURL reconstructs the scheme + host a request arrived on, for
+// building absolute URLs in generated documents like opensearch.xml.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecentQueriesSuggestMatchesPrefixCaseInsensitively(t *testing.T) {
+	r := newRecentQueries()
+	r.Record("Foo Bar")
+	r.Record("foo baz")
+	r.Record("unrelated")
+
+	got := r.Suggest("FOO", 10)
+	if len(got) != 2 {
+		t.Fatalf("Suggest(%q) = %v, want 2 matches", "FOO", got)
+	}
+}
+
+func TestRecentQueriesRecordDedupsAndMovesToFront(t *testing.T) {
+	r := newRecentQueries()
+	r.Record("a")
+	r.Record("b")
+	r.Record("a") // re-recording "a" should move it to front, not duplicate it
+
+	got := r.Suggest("", 10)
+	if len(got) != 2 {
+		t.Fatalf("got %v, want 2 distinct queries", got)
+	}
+	if got[0] != "a" {
+		t.Errorf("got[0] = %q, want %q to be most recent", got[0], "a")
+	}
+}
+
+func TestRecentQueriesEvictsOldest(t *testing.T) {
+	r := newRecentQueries()
+	for i := 0; i < maxRecentQueries+1; i++ {
+		r.Record(fmt.Sprintf("q%d", i))
+	}
+
+	if got := r.Suggest("q0", 1); len(got) != 0 {
+		t.Errorf("expected the oldest query to have been evicted, got %v", got)
+	}
+	if got := r.Suggest(fmt.Sprintf("q%d", maxRecentQueries), 1); len(got) != 1 {
+		t.Errorf("expected the newest query to still be cached, got %v", got)
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// renderCacheSize bounds the number of rendered pages kept per
+// template set. The search shell and about page are effectively
+// static per deploy, so a handful of entries covers every distinct
+// context (theme, production flag, page title) in practice.
+const renderCacheSize = 64
+
+// renderCacheEntry is a fully-rendered page, cached so a repeat
+// request for the same (template version, context) skips template
+// execution entirely.
+type renderCacheEntry struct {
+	body []byte
+	etag string
+}
+
+// renderCache is a small in-process LRU from renderCacheKey to
+// renderCacheEntry, plus hit/miss counters for the debug endpoint.
+type renderCache struct {
+	max int
+
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+
+	hits, misses int64
+}
+
+type renderCacheElem struct {
+	key   string
+	entry renderCacheEntry
+}
+
+func newRenderCache(max int) *renderCache {
+	return &renderCache{
+		max:   max,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *renderCache) Get(key string) (renderCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return renderCacheEntry{}, false
+	}
+	c.order.MoveToFront(el)
+	atomic.AddInt64(&c.hits, 1)
+	return el.Value.(*renderCacheElem).entry, true
+}
+
+func (c *renderCache) Put(key string, entry renderCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*renderCacheElem).entry = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	el := c.order.PushFront(&renderCacheElem{key: key, entry: entry})
+	c.items[key] = el
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*renderCacheElem).key)
+	}
+}
+
+// renderCacheStats is the JSON shape returned by the debug endpoint.
+type renderCacheStats struct {
+	Size   int   `json:"size"`
+	Max    int   `json:"max"`
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+func (c *renderCache) Stats() renderCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return renderCacheStats{
+		Size:   c.order.Len(),
+		Max:    c.max,
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+	}
+}
+
+// renderCacheKey identifies a cacheable render: the template set's
+// version (so a reload can't return stale content from before it)
+// and every field of page that affects the rendered bytes.
+func renderCacheKey(version uint64, p *page) string {
+	return fmt.Sprintf("v%d|js=%t|prod=%t|title=%s|body=%x",
+		version, p.IncludeJS, p.Production, p.Title, sha256.Sum256([]byte(p.Body)))
+}
+
+// strongETag computes a strong ETag over the template set version
+// and the rendered bytes, so it changes both when the content
+// differs and when the templates producing it are reloaded.
+func strongETag(version uint64, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "v%d|", version)
+	h.Write(body)
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// ServeDebugRenderCache reports render cache hit/miss stats for the
+// server's un-themed template set and each registered theme, for use
+// from an operator-only debug/admin endpoint.
+func (s *server) ServeDebugRenderCache(w http.ResponseWriter, r *http.Request) {
+	out := make(map[string]renderCacheStats)
+	out["default"] = s.t.current().cache.Stats()
+	for name, th := range s.registeredThemes() {
+		if stats, ok := themeRenderCacheStats(th); ok {
+			out[name] = stats
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(out)
+}
+
+// themeRenderCacheStats returns th's render cache stats, recovering if
+// loading th's templates panics (e.g. a theme with a broken template
+// file, via template.Must) so one bad theme can't take down stats for
+// every other registered theme.
+func themeRenderCacheStats(th *Theme) (stats renderCacheStats, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return th.Templates().cache.Stats(), true
+}
@@ -0,0 +1,53 @@
+package server
+
+import "testing"
+
+func TestRenderCacheEvictsOldest(t *testing.T) {
+	c := newRenderCache(2)
+	c.Put("a", renderCacheEntry{body: []byte("a")})
+	c.Put("b", renderCacheEntry{body: []byte("b")})
+	c.Put("c", renderCacheEntry{body: []byte("c")}) // over max(2): evicts "a"
+
+	if _, ok := c.Get("a"); ok {
+		t.Error(`"a" should have been evicted`)
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error(`"b" should still be cached`)
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error(`"c" should still be cached`)
+	}
+}
+
+func TestRenderCacheGetTouchKeepsEntryAlive(t *testing.T) {
+	c := newRenderCache(2)
+	c.Put("a", renderCacheEntry{body: []byte("a")})
+	c.Put("b", renderCacheEntry{body: []byte("b")})
+	c.Get("a") // touch "a" so "b" becomes the least recently used entry
+	c.Put("c", renderCacheEntry{body: []byte("c")})
+
+	if _, ok := c.Get("a"); !ok {
+		t.Error(`"a" should still be cached after being touched`)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error(`"b" should have been evicted`)
+	}
+}
+
+func TestRenderCacheStatsCountHitsAndMisses(t *testing.T) {
+	c := newRenderCache(4)
+	c.Put("a", renderCacheEntry{body: []byte("a")})
+	c.Get("a")
+	c.Get("missing")
+
+	stats := c.Stats()
+	if stats.Hits != 1 {
+		t.Errorf("Hits = %d, want 1", stats.Hits)
+	}
+	if stats.Misses != 1 {
+		t.Errorf("Misses = %d, want 1", stats.Misses)
+	}
+	if stats.Size != 1 {
+		t.Errorf("Size = %d, want 1", stats.Size)
+	}
+}
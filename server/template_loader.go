@@ -0,0 +1,133 @@
+package server
+
+import (
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TemplateLoader knows how to parse a set of named templates from
+// some backing store. Implementations exist for the local disk, an
+// arbitrary fs.FS (so a production binary can embed its templates
+// with embed.FS), and a fixed in-memory map for tests.
+type TemplateLoader interface {
+	// Load parses the named templates, relative to the loader's
+	// root, and returns the combined template.
+	Load(names ...string) (*template.Template, error)
+}
+
+// fsLoader loads templates out of an fs.FS rooted at root. It backs
+// both NewDiskTemplateLoader and embed.FS-based production builds.
+type fsLoader struct {
+	fs   fs.FS
+	root string
+}
+
+// NewFSTemplateLoader returns a TemplateLoader that parses templates
+// named relative to root within fsys. Pass an embed.FS here to ship
+// templates inside the server binary.
+func NewFSTemplateLoader(fsys fs.FS, root string) TemplateLoader {
+	return &fsLoader{fs: fsys, root: root}
+}
+
+// NewDiskTemplateLoader returns a TemplateLoader that reads templates
+// from the "templates" directory under docRoot.
+func NewDiskTemplateLoader(docRoot string) TemplateLoader {
+	return NewFSTemplateLoader(os.DirFS(docRoot), "templates")
+}
+
+func (l *fsLoader) Load(names ...string) (*template.Template, error) {
+	paths := make([]string, 0, len(names))
+	for _, n := range names {
+		paths = append(paths, path.Join(l.root, n))
+	}
+	return template.ParseFS(l.fs, paths...)
+}
+
+// mapLoader serves templates from a fixed in-memory map of name to
+// source, for use in tests that shouldn't depend on the filesystem.
+type mapLoader map[string]string
+
+// NewMapTemplateLoader returns a TemplateLoader backed by a literal
+// map of template name to source text.
+func NewMapTemplateLoader(sources map[string]string) TemplateLoader {
+	return mapLoader(sources)
+}
+
+func (l mapLoader) Load(names ...string) (*template.Template, error) {
+	var t *template.Template
+	for _, n := range names {
+		src, ok := l[n]
+		if !ok {
+			return nil, &fs.PathError{Op: "load", Path: n, Err: fs.ErrNotExist}
+		}
+		var tmpl *template.Template
+		if t == nil {
+			t = template.New(n)
+			tmpl = t
+		} else {
+			tmpl = t.New(n)
+		}
+		if _, err := tmpl.Parse(src); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// reloadingLoader wraps a disk-backed TemplateLoader and reports,
+// through Changed, whenever any file under docRoot's "templates"
+// directory has changed since the last Load, so callers can re-parse
+// and edits made while developing a theme show up on the next request
+// without restarting the server. It is not meant for production use,
+// where the doc root is typically immutable (or embedded) and the extra stat calls on
+// every request are wasted work.
+type reloadingLoader struct {
+	inner   TemplateLoader
+	docRoot string
+
+	mu      sync.Mutex
+	lastMod time.Time
+}
+
+// NewReloadingTemplateLoader wraps inner with mtime-based change
+// detection rooted at docRoot/templates. Load always re-parses through
+// inner and records the newest mtime it saw; Changed reports whether a
+// newer one has appeared since, so the caller decides when to Load again.
+func NewReloadingTemplateLoader(inner TemplateLoader, docRoot string) TemplateLoader {
+	return &reloadingLoader{inner: inner, docRoot: docRoot}
+}
+
+func (l *reloadingLoader) Load(names ...string) (*template.Template, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastMod = l.templatesModTime()
+	return l.inner.Load(names...)
+}
+
+// Changed reports whether any file under docRoot/templates has a
+// newer mtime than the last call to Load.
+func (l *reloadingLoader) Changed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.templatesModTime().After(l.lastMod)
+}
+
+func (l *reloadingLoader) templatesModTime() time.Time {
+	var latest time.Time
+	root := filepath.Join(l.docRoot, "templates")
+	filepath.Walk(root, func(_ string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if mod := info.ModTime(); mod.After(latest) {
+			latest = mod
+		}
+		return nil
+	})
+	return latest
+}
@@ -2,17 +2,44 @@ package server
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/nelhage/livegrep/config"
 	"html/template"
-	"io"
-	"path"
+	"net/http"
+	"sync"
+	"time"
 )
 
 type templates struct {
 	layout,
 	searchPage,
 	aboutPage,
-	opensearchXML *template.Template
+	opensearchXML,
+	errorPage *template.Template
+
+	loader   TemplateLoader
+	loadedAt time.Time
+
+	recentQueries *recentQueries
+	backendTokens *backendTokenSource
+	symbolSource  SuggestionSource
+
+	themes themes
+
+	// version increments every time the template set is
+	// (re)parsed, so cached renders from before a reload never
+	// collide with ones from after it.
+	version uint64
+	cache   *renderCache
+
+	errorHook func(ErrorEvent)
+
+	// mu guards every field above that a dev-mode reload and
+	// concurrent request handling can both touch: the loader, the
+	// parsed templates, version, loadedAt, the render cache and the
+	// theme registry. Reads happen through current(), which takes a
+	// race-free snapshot rather than handing out s.t's own pointer.
+	mu sync.Mutex
 }
 
 type page struct {
@@ -25,6 +52,11 @@ type page struct {
 type opensearchContext struct {
 	BackendName string
 	BaseURL     string
+	// SuggestURL, when non-empty, is advertised in the generated
+	// OpenSearch description as an
+	// application/x-suggestions+json Url, so browsers offer inline
+	// completions from ServeOpenSearchSuggest.
+	SuggestURL string
 }
 
 type searchContext struct {
@@ -32,15 +64,85 @@ type searchContext struct {
 	Backends    []config.Backend
 }
 
+// templateSet is a race-free snapshot of a templates value's current
+// generation: every parsed template, the version and load time they
+// belong to, and the render cache built for them. Obtain one through
+// templates.current() rather than reading a templates value's fields
+// directly, since a dev-mode reload can replace them concurrently.
+type templateSet struct {
+	layout,
+	searchPage,
+	aboutPage,
+	opensearchXML,
+	errorPage *template.Template
+
+	version  uint64
+	loadedAt time.Time
+	cache    *renderCache
+}
+
+// current returns a snapshot of t's current generation, lazily
+// creating the render cache on first use.
+func (t *templates) current() *templateSet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cache == nil {
+		t.cache = newRenderCache(renderCacheSize)
+	}
+	return &templateSet{
+		layout:        t.layout,
+		searchPage:    t.searchPage,
+		aboutPage:     t.aboutPage,
+		opensearchXML: t.opensearchXML,
+		errorPage:     t.errorPage,
+		version:       t.version,
+		loadedAt:      t.loadedAt,
+		cache:         t.cache,
+	}
+}
+
+// hasThemes reports whether any themes have been registered.
+func (t *templates) hasThemes() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.themes) > 0
+}
+
+// templateLoader returns the TemplateLoader this server should parse
+// its templates with: a disk loader wrapped with mtime-based reload
+// in dev mode, or a plain disk loader in production.
+func (s *server) templateLoader() TemplateLoader {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	if s.t.loader != nil {
+		return s.t.loader
+	}
+	loader := NewDiskTemplateLoader(s.config.DocRoot)
+	if !s.config.Production {
+		loader = NewReloadingTemplateLoader(loader, s.config.DocRoot)
+	}
+	s.t.loader = loader
+	return loader
+}
+
 func (s *server) readTemplates(files ...string) *template.Template {
-	filenames := make([]string, 0, len(files))
-	for _, f := range files {
-		filenames = append(filenames, path.Join(s.config.DocRoot, "templates", f))
+	t, err := s.templateLoader().Load(files...)
+	if err != nil {
+		panic(err)
 	}
-	return template.Must(template.ParseFiles(filenames...))
+	return t
 }
 
-func (s *server) executeTemplate(t *template.Template, context interface{}) ([]byte, error) {
+func (s *server) executeTemplate(t *template.Template, context interface{}) (b []byte, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			if e, ok := rec.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", rec)
+			}
+		}
+	}()
 	var buf bytes.Buffer
 	if err := t.Execute(&buf, context); err != nil {
 		return nil, err
@@ -48,8 +150,98 @@ func (s *server) executeTemplate(t *template.Template, context interface{}) ([]b
 	return buf.Bytes(), nil
 }
 
-func (s *server) renderPage(w io.Writer, p *page) {
+// reloadTemplates re-parses the layout, search page, about page,
+// opensearch and error templates through the server's loader, then
+// swaps them into s.t together with a bumped version under a single
+// lock, so no request can ever observe a version that doesn't match
+// the templates it was parsed with. Parsing happens before the lock
+// is taken, so a loader panic partway through (e.g. one bad template
+// file) can't leave s.t with some fields from the new generation and
+// some from the old one. Called at startup, and again on every
+// request in dev mode once the reloading loader notices a changed
+// file underneath templates/.
+func (s *server) reloadTemplates() {
+	layout := s.readTemplates("layout.html")
+	searchPage := s.readTemplates("layout.html", "searchpage.html")
+	aboutPage := s.readTemplates("layout.html", "aboutpage.html")
+	opensearchXML := s.readTemplates("opensearch.xml")
+	errorPage := s.readTemplates("layout.html", "errorpage.html")
+
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.layout = layout
+	s.t.searchPage = searchPage
+	s.t.aboutPage = aboutPage
+	s.t.opensearchXML = opensearchXML
+	s.t.errorPage = errorPage
+	s.t.loadedAt = time.Now()
+	s.t.version++
+}
+
+func (s *server) maybeReloadTemplates() {
+	if l, ok := s.templateLoader().(*reloadingLoader); ok && l.Changed() {
+		s.reloadTemplates()
+	}
+}
+
+// resolveTemplates returns the template set renderPage should use
+// for r: if any themes are registered (registerTheme), the theme
+// selected for this request (see themeForRequest, which may write a
+// Set-Cookie onto w to persist that selection); otherwise the
+// server's single, un-themed template set, reloaded first if dev mode
+// says it changed.
+func (s *server) resolveTemplates(w http.ResponseWriter, r *http.Request) *templateSet {
+	if !s.t.hasThemes() {
+		s.maybeReloadTemplates()
+		return s.t.current()
+	}
+	return s.themeForRequest(w, r).Templates()
+}
+
+// renderPage renders p through the layout template selected for r,
+// serving from the in-process render cache when possible and setting
+// a strong ETag so repeat requests for unchanged content can be
+// answered with 304 Not Modified.
+func (s *server) renderPage(w http.ResponseWriter, r *http.Request, p *page) {
+	defer s.recoverRender(w, r)
+
+	tmpl := s.resolveTemplates(w, r)
 	p.Production = s.config.Production
-	s.t.layout.Execute(w, p)
 
+	key := renderCacheKey(tmpl.version, p)
+
+	entry, ok := tmpl.cache.Get(key)
+	if !ok {
+		body, err := s.executeTemplate(tmpl.layout, p)
+		if err != nil {
+			s.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		entry = renderCacheEntry{body: body, etag: strongETag(tmpl.version, body)}
+		tmpl.cache.Put(key, entry)
+	}
+
+	w.Header().Set("ETag", entry.etag)
+	w.Header().Set("Last-Modified", tmpl.loadedAt.UTC().Format(http.TimeFormat))
+	if notModified(r, entry.etag, tmpl.loadedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(entry.body)
+}
+
+// notModified reports whether r's conditional headers indicate the
+// cached response identified by etag/modTime can be skipped in favor
+// of a 304. If-None-Match takes precedence over If-Modified-Since per
+// RFC 7232 §6, so a client sending both gets the more precise check.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return inm == etag
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if since, err := http.ParseTime(ims); err == nil {
+			return !modTime.Truncate(time.Second).After(since)
+		}
+	}
+	return false
 }
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeFile writes contents to path, creating any parent directories
+// needed. Shared by the template-loading tests in this package.
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTemplatesCurrentIsRaceFree drives reloads (swapping the layout and
+// bumping the version under mu) and current() snapshots from many
+// goroutines at once. Run with -race, it catches data races between
+// dev-mode reload and request handling; it also checks that every
+// snapshot carries a parsed layout and a non-zero version. Every reload
+// parses the same file, so it cannot tell a layout paired with another
+// generation's version from a matching one.
+func TestTemplatesCurrentIsRaceFree(t *testing.T) {
+	docRoot := t.TempDir()
+	writeFile(t, filepath.Join(docRoot, "templates", "layout.html"), "layout")
+
+	tmpl := &templates{}
+	reload := func() {
+		loader := NewDiskTemplateLoader(docRoot)
+		layout, err := loader.Load("layout.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.mu.Lock()
+		tmpl.layout = layout
+		tmpl.loadedAt = time.Now()
+		tmpl.version++
+		tmpl.mu.Unlock()
+	}
+	reload()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				reload()
+			}
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				snap := tmpl.current()
+				if snap.layout == nil || snap.version == 0 {
+					t.Errorf("current() returned an incomplete snapshot: %+v", snap)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestNotModified exercises the header combinations renderPage relies
+// on to decide between a 304 and a full render: If-None-Match takes
+// precedence over If-Modified-Since per RFC 7232 §6 when a client
+// sends both, a matching ETag alone is enough, and If-Modified-Since
+// is honored on its own before and after the content's load time.
+func TestNotModified(t *testing.T) {
+	const etag = `"abc123"`
+	loadedAt := time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name            string
+		ifNoneMatch     string
+		ifModifiedSince time.Time
+		want            bool
+	}{
+		{name: "no conditional headers", want: false},
+		{name: "matching If-None-Match", ifNoneMatch: etag, want: true},
+		{name: "mismatched If-None-Match", ifNoneMatch: `"other"`, want: false},
+		{name: "If-Modified-Since after load time", ifModifiedSince: loadedAt.Add(time.Hour), want: true},
+		{name: "If-Modified-Since before load time", ifModifiedSince: loadedAt.Add(-time.Hour), want: false},
+		{name: "If-Modified-Since equal to load time", ifModifiedSince: loadedAt, want: true},
+		{
+			name:            "mismatched If-None-Match wins over a satisfied If-Modified-Since",
+			ifNoneMatch:     `"other"`,
+			ifModifiedSince: loadedAt.Add(time.Hour),
+			want:            false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if c.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", c.ifNoneMatch)
+			}
+			if !c.ifModifiedSince.IsZero() {
+				r.Header.Set("If-Modified-Since", c.ifModifiedSince.UTC().Format(http.TimeFormat))
+			}
+			if got := notModified(r, etag, loadedAt); got != c.want {
+				t.Errorf("notModified() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
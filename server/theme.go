@@ -0,0 +1,265 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// themeCookie is the cookie livegrep reads and writes to remember a
+// visitor's chosen theme across requests.
+const themeCookie = "livegrep_theme"
+
+// themeQueryParam lets a request pick a theme for just that request,
+// e.g. for linking someone directly to the dark theme.
+const themeQueryParam = "theme"
+
+// defaultThemeName is served when a request names no theme, or names
+// one that isn't registered.
+const defaultThemeName = "default"
+
+// Theme is a named, independently-loadable set of templates
+// (layout/searchPage/aboutPage/...), optionally inheriting from a
+// parent theme. A theme lives under templates/themes/<name>/ and
+// only needs to provide the files it wants to change: anything it
+// doesn't override falls through to its parent, and ultimately to
+// the un-themed templates/ directory, so a deployment can rebrand
+// livegrep without forking the whole template tree.
+type Theme struct {
+	Name   string
+	Parent *Theme
+
+	docRoot string
+	dev     bool
+
+	tmpl templates
+}
+
+func newTheme(name string, parent *Theme, docRoot string, dev bool) *Theme {
+	return &Theme{Name: name, Parent: parent, docRoot: docRoot, dev: dev}
+}
+
+// Templates returns a race-free snapshot of this theme's current
+// template set, parsing it on first use and re-parsing it whenever
+// dev mode notices a changed file under its loader's directories.
+func (t *Theme) Templates() *templateSet {
+	t.ensureLoaded()
+	return t.tmpl.current()
+}
+
+// ensureLoaded (re)parses this theme's templates into t.tmpl if
+// they've never been parsed, or if dev-mode reload detected a
+// changed file. Parsing happens outside t.tmpl's lock so a loader
+// panic can't leave t.tmpl half-updated; the result is swapped in,
+// together with a bumped version, under a single lock.
+func (t *Theme) ensureLoaded() {
+	loader, stale := t.loaderAndStaleness()
+	if !stale {
+		return
+	}
+
+	layout := template.Must(loader.Load("layout.html"))
+	searchPage := template.Must(loader.Load("layout.html", "searchpage.html"))
+	aboutPage := template.Must(loader.Load("layout.html", "aboutpage.html"))
+	opensearchXML := template.Must(loader.Load("opensearch.xml"))
+	errorPage := template.Must(loader.Load("layout.html", "errorpage.html"))
+
+	t.tmpl.mu.Lock()
+	defer t.tmpl.mu.Unlock()
+	t.tmpl.layout = layout
+	t.tmpl.searchPage = searchPage
+	t.tmpl.aboutPage = aboutPage
+	t.tmpl.opensearchXML = opensearchXML
+	t.tmpl.errorPage = errorPage
+	t.tmpl.loadedAt = time.Now()
+	t.tmpl.version++
+}
+
+// loaderAndStaleness returns this theme's loader, creating it under
+// lock on first use, and whether the template set needs (re)parsing:
+// either because it has never been parsed, or because dev-mode
+// reload detected a changed file.
+func (t *Theme) loaderAndStaleness() (TemplateLoader, bool) {
+	t.tmpl.mu.Lock()
+	defer t.tmpl.mu.Unlock()
+	if t.tmpl.loader == nil {
+		t.tmpl.loader = t.loader()
+	}
+	if t.tmpl.layout == nil {
+		return t.tmpl.loader, true
+	}
+	rl, ok := t.tmpl.loader.(*reloadingLoader)
+	return t.tmpl.loader, ok && rl.Changed()
+}
+
+// loader builds the TemplateLoader for this theme: its own directory
+// first, then each ancestor's, then the base templates/ directory,
+// wrapped with mtime-based reload in dev mode.
+func (t *Theme) loader() TemplateLoader {
+	var layers []fsLoader
+	for cur := t; cur != nil; cur = cur.Parent {
+		layers = append(layers, fsLoader{
+			fs:   os.DirFS(cur.docRoot),
+			root: path.Join("templates", "themes", cur.Name),
+		})
+	}
+	layers = append(layers, fsLoader{fs: os.DirFS(t.docRoot), root: "templates"})
+
+	var loader TemplateLoader = &themeLoader{layers: layers}
+	if t.dev {
+		loader = NewReloadingTemplateLoader(loader, t.docRoot)
+	}
+	return loader
+}
+
+// themeLoader resolves each requested template name against an
+// ordered list of layers (most-specific theme first, ending with the
+// un-themed base directory), taking the first layer that has the
+// file, and parses the result into a single template tree.
+type themeLoader struct {
+	layers []fsLoader
+}
+
+func (l *themeLoader) Load(names ...string) (*template.Template, error) {
+	var root *template.Template
+	for _, name := range names {
+		fsys, filename, err := l.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		src, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			return nil, err
+		}
+		var t *template.Template
+		if root == nil {
+			root = template.New(name)
+			t = root
+		} else {
+			t = root.New(name)
+		}
+		if _, err := t.Parse(string(src)); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+func (l *themeLoader) resolve(name string) (fs.FS, string, error) {
+	for _, layer := range l.layers {
+		p := path.Join(layer.root, name)
+		if _, err := fs.Stat(layer.fs, p); err == nil {
+			return layer.fs, p, nil
+		}
+	}
+	return nil, "", fmt.Errorf("theme: no layer provides template %q", name)
+}
+
+// themes registers the set of Theme values a server knows how to
+// serve, keyed by name.
+type themes map[string]*Theme
+
+// registerTheme adds a theme to the registry. parentName may be ""
+// for a theme with no parent (other than the base templates/
+// directory).
+func (s *server) registerTheme(name, parentName string) *Theme {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	if s.t.themes == nil {
+		s.t.themes = make(themes)
+	}
+	var parent *Theme
+	if parentName != "" {
+		parent = s.t.themes[parentName]
+	}
+	t := newTheme(name, parent, s.config.DocRoot, !s.config.Production)
+	s.t.themes[name] = t
+	return t
+}
+
+// theme looks up a registered theme by name, falling back to
+// defaultThemeName (registering it on demand) if name is unknown.
+func (s *server) theme(name string) *Theme {
+	s.t.mu.Lock()
+	if t, ok := s.t.themes[name]; ok {
+		s.t.mu.Unlock()
+		return t
+	}
+	if t, ok := s.t.themes[defaultThemeName]; ok {
+		s.t.mu.Unlock()
+		return t
+	}
+	s.t.mu.Unlock()
+	return s.registerTheme(defaultThemeName, "")
+}
+
+// registeredThemes returns a snapshot copy of the theme registry, for
+// callers (like the debug render-cache endpoint) that need to range
+// over every registered theme without holding s.t's lock while they
+// do so.
+func (s *server) registeredThemes() map[string]*Theme {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	out := make(map[string]*Theme, len(s.t.themes))
+	for name, th := range s.t.themes {
+		out[name] = th
+	}
+	return out
+}
+
+// themeForRequest picks the theme to render a request with, checking
+// each selection mechanism in turn: an explicit ?theme= query
+// parameter (which it persists back to the livegrep_theme cookie so
+// it sticks on later requests that don't repeat it), then the cookie
+// itself, then Accept-header negotiation, then the server default.
+func (s *server) themeForRequest(w http.ResponseWriter, r *http.Request) *Theme {
+	if name := r.URL.Query().Get(themeQueryParam); name != "" {
+		t := s.theme(name)
+		setThemeCookie(w, t.Name)
+		return t
+	}
+	if c, err := r.Cookie(themeCookie); err == nil && c.Value != "" {
+		return s.theme(c.Value)
+	}
+	if name := themeFromAccept(r.Header.Get("Accept")); name != "" {
+		return s.theme(name)
+	}
+	return s.theme(defaultThemeName)
+}
+
+// setThemeCookie persists a visitor's explicit theme selection so it
+// sticks across future requests without repeating ?theme=, matching
+// themeCookie's doc comment: livegrep both reads and writes it.
+func setThemeCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   themeCookie,
+		Value:  name,
+		Path:   "/",
+		MaxAge: 365 * 24 * 60 * 60,
+	})
+}
+
+// themeFromAccept looks for a theme requested via Accept header
+// content negotiation: a client can ask for a themed variant with a
+// "theme" media type parameter, e.g. "Accept: text/html;theme=dark",
+// the same mechanism used for API versioning (e.g. GitHub's
+// "application/vnd.github.v3+json"). The first media range naming a
+// theme wins.
+func themeFromAccept(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if name := params["theme"]; name != "" {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// TestThemeInheritanceResolvesToNearestLayer checks that a theme's
+// loader picks the most specific layer that provides a given
+// template: the theme's own directory, then its parent's, then the
+// un-themed base templates/ directory.
+func TestThemeInheritanceResolvesToNearestLayer(t *testing.T) {
+	docRoot := t.TempDir()
+	writeFile(t, filepath.Join(docRoot, "templates", "layout.html"), "base-layout")
+	writeFile(t, filepath.Join(docRoot, "templates", "aboutpage.html"), "base-about")
+	writeFile(t, filepath.Join(docRoot, "templates", "themes", "parent", "searchpage.html"), "parent-search")
+	writeFile(t, filepath.Join(docRoot, "templates", "themes", "child", "layout.html"), "child-layout")
+
+	parent := newTheme("parent", nil, docRoot, false)
+	child := newTheme("child", parent, docRoot, false)
+	loader := child.loader()
+
+	cases := []struct {
+		file string
+		want string
+	}{
+		{"layout.html", "child-layout"},      // overridden by the child theme
+		{"searchpage.html", "parent-search"}, // inherited from the parent theme
+		{"aboutpage.html", "base-about"},     // falls through to the un-themed base
+	}
+	for _, c := range cases {
+		tmpl, err := loader.Load(c.file)
+		if err != nil {
+			t.Fatalf("Load(%q): %v", c.file, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			t.Fatalf("Execute(%q): %v", c.file, err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("Load(%q) rendered %q, want %q", c.file, got, c.want)
+		}
+	}
+}
+
+// TestThemeInheritanceMissingTemplate checks that a name provided by
+// no layer in the chain is reported as an error, not silently
+// dropped.
+func TestThemeInheritanceMissingTemplate(t *testing.T) {
+	docRoot := t.TempDir()
+	writeFile(t, filepath.Join(docRoot, "templates", "layout.html"), "base-layout")
+
+	theme := newTheme("child", nil, docRoot, false)
+	if _, err := theme.loader().Load("nope.html"); err == nil {
+		t.Fatal("Load of a template no layer provides should have failed")
+	}
+}
+
+func TestThemeFromAccept(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"text/html;theme=dark", "dark"},
+		{"application/json, text/html;theme=compact;q=0.9", "compact"},
+		{"text/html", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := themeFromAccept(c.accept); got != c.want {
+			t.Errorf("themeFromAccept(%q) = %q, want %q", c.accept, got, c.want)
+		}
+	}
+}
+
+func TestSetThemeCookiePersistsSelection(t *testing.T) {
+	w := httptest.NewRecorder()
+	setThemeCookie(w, "dark")
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != themeCookie || cookies[0].Value != "dark" {
+		t.Fatalf("expected a %s=dark cookie to be set, got %v", themeCookie, cookies)
+	}
+}